@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpConnectDialer tunnels TCP connections through an HTTP proxy via
+// CONNECT.
+type httpConnectDialer struct {
+	proxyAddr string
+	timeout   time.Duration
+	user      string
+	pass      string
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", d.proxyAddr, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(d.timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	connectReq, err := http.NewRequest(http.MethodConnect, "http://"+addr, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("building CONNECT request for %s: %w", addr, err)
+	}
+	connectReq.Host = addr
+	if d.user != "" {
+		connectReq.Header.Set("Proxy-Authorization", basicAuth(d.user, d.pass))
+	}
+
+	if err := connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("writing CONNECT request to %s: %w", d.proxyAddr, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading CONNECT response from %s: %w", d.proxyAddr, err)
+	}
+	// Deliberately not closing resp.Body: a 2xx CONNECT response has no
+	// body, and http.Response.Body.Close() drains to EOF when there's
+	// no Content-Length/chunked framing, which would hang forever on a
+	// live tunnel.
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT to %s via %s failed: %s", addr, d.proxyAddr, resp.Status)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// reader may already hold bytes the proxy sent past the CONNECT
+	// response (e.g. the first TLS record, if it arrived in the same
+	// packet); bufferedConn makes sure those aren't dropped.
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+func basicAuth(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+// bufferedConn wraps a net.Conn whose initial bytes may already sit in
+// a bufio.Reader, and reads from that buffer before falling through to
+// the underlying connection.
+type bufferedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFakeConnectProxy accepts one connection, drains the CONNECT
+// request line and headers, then writes respBytes in a single Write
+// call before idling so the client has time to read any bytes
+// appended past the response headers.
+func startFakeConnectProxy(t *testing.T, respBytes []byte) net.Addr {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+
+		if _, err := conn.Write(respBytes); err != nil {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}()
+
+	return ln.Addr()
+}
+
+func TestHTTPConnectDialerAcceptsAny2xx(t *testing.T) {
+	addr := startFakeConnectProxy(t, []byte("HTTP/1.1 201 Connection Established\r\n\r\n"))
+
+	d := &httpConnectDialer{proxyAddr: addr.String(), timeout: 2 * time.Second}
+	conn, err := d.Dial("tcp", "example.roblox.com:443")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+}
+
+func TestHTTPConnectDialerRejectsNon2xx(t *testing.T) {
+	addr := startFakeConnectProxy(t, []byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+
+	d := &httpConnectDialer{proxyAddr: addr.String(), timeout: 2 * time.Second}
+	if _, err := d.Dial("tcp", "example.roblox.com:443"); err == nil {
+		t.Fatal("expected Dial to fail for a non-2xx CONNECT response")
+	}
+}
+
+func TestHTTPConnectDialerPreservesBytesBufferedPastHeaders(t *testing.T) {
+	extra := []byte{0x16, 0x03, 0x01, 0x00, 0x05} // start of a TLS record, sent in the same packet as the CONNECT response
+	resp := append([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"), extra...)
+	addr := startFakeConnectProxy(t, resp)
+
+	d := &httpConnectDialer{proxyAddr: addr.String(), timeout: 2 * time.Second}
+	conn, err := d.Dial("tcp", "example.roblox.com:443")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	got := make([]byte, len(extra))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("reading bytes buffered past the CONNECT response: %v", err)
+	}
+	if string(got) != string(extra) {
+		t.Fatalf("lost bytes buffered past the CONNECT response: got %v, want %v", got, extra)
+	}
+}
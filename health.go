@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	unhealthyThreshold       = 3
+	healthyCheckInterval     = 30 * time.Second
+	unhealthyRecheckInterval = 2 * time.Minute
+	healthCheckTimeout       = 10 * time.Second
+)
+
+// checker is the process-wide health-checker instance, started from
+// main() once the proxy pools are loaded.
+var checker *healthChecker
+
+// proxyHealth tracks the rolling health of a single proxy, keyed by
+// "ip:port" in healthChecker.statuses.
+type proxyHealth struct {
+	mu               sync.Mutex
+	Healthy          bool      `json:"healthy"`
+	ConsecutiveFails int       `json:"-"`
+	Checks           int       `json:"checks"`
+	Successes        int       `json:"successes"`
+	AvgLatencyMs     float64   `json:"avgLatencyMs"`
+	LastError        string    `json:"lastError,omitempty"`
+	LastCheckedAt    time.Time `json:"lastCheckedAt"`
+}
+
+// healthChecker periodically probes every proxy in a worker pool and
+// evicts ones that fail unhealthyThreshold checks in a row.
+type healthChecker struct {
+	mu              sync.RWMutex
+	statuses        map[string]*proxyHealth
+	ipCheckerURL    string
+	healthCheckURLs []string
+	localIP         string
+	workers         int
+}
+
+func newHealthChecker(cfg *Config) *healthChecker {
+	workers := cfg.ProxyCheckers
+	if workers < 1 {
+		workers = 50
+	}
+	return &healthChecker{
+		statuses:        make(map[string]*proxyHealth),
+		ipCheckerURL:    cfg.IPCheckerURL,
+		healthCheckURLs: cfg.HealthCheckURLs,
+		workers:         workers,
+	}
+}
+
+func proxyKey(p *Proxy) string {
+	return net.JoinHostPort(p.IP, p.Port)
+}
+
+// start runs the scheduling loop and worker pool. It blocks forever;
+// call it in a goroutine.
+func (hc *healthChecker) start() {
+	if hc.ipCheckerURL == "" {
+		log.Printf("⚠️  No ip_checker_url configured, proxy health checker disabled")
+		return
+	}
+
+	if ip, err := fetchEgressIP(hc.ipCheckerURL, nil); err == nil {
+		hc.localIP = ip
+		log.Printf("🌐 Local egress IP for health checks: %s", hc.localIP)
+	} else {
+		log.Printf("⚠️  Failed to determine local egress IP: %v", err)
+	}
+
+	jobs := make(chan Proxy, hc.workers)
+	for i := 0; i < hc.workers; i++ {
+		go hc.worker(jobs)
+	}
+
+	hc.enqueueAll(jobs, proxies)
+
+	healthyTicker := time.NewTicker(healthyCheckInterval)
+	unhealthyTicker := time.NewTicker(unhealthyRecheckInterval)
+	defer healthyTicker.Stop()
+	defer unhealthyTicker.Stop()
+
+	for {
+		select {
+		case <-healthyTicker.C:
+			hc.enqueueAll(jobs, hc.filterByHealth(proxies, true))
+		case <-unhealthyTicker.C:
+			hc.enqueueAll(jobs, hc.filterByHealth(proxies, false))
+		}
+	}
+}
+
+func (hc *healthChecker) enqueueAll(jobs chan<- Proxy, list []Proxy) {
+	for _, p := range list {
+		jobs <- p
+	}
+}
+
+func (hc *healthChecker) filterByHealth(all []Proxy, healthy bool) []Proxy {
+	var out []Proxy
+	for _, p := range all {
+		if hc.isHealthy(&p) == healthy {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (hc *healthChecker) worker(jobs <-chan Proxy) {
+	for p := range jobs {
+		hc.check(p)
+	}
+}
+
+func (hc *healthChecker) check(p Proxy) {
+	key := proxyKey(&p)
+
+	dialer, err := getProxyDialer(&p)
+	if err != nil {
+		hc.recordFailure(key, err)
+		return
+	}
+
+	probeClient := &fasthttp.Client{
+		ReadTimeout:  healthCheckTimeout,
+		WriteTimeout: healthCheckTimeout,
+		Dial:         fasthttpDial(dialer),
+	}
+
+	start := time.Now()
+	ip, err := fetchEgressIP(hc.ipCheckerURL, probeClient)
+	latency := time.Since(start)
+	if err != nil {
+		hc.recordFailure(key, err)
+		return
+	}
+	if hc.localIP != "" && ip == hc.localIP {
+		hc.recordFailure(key, fmt.Errorf("proxy returned our own egress IP %s, not forwarding traffic", ip))
+		return
+	}
+
+	for _, url := range hc.healthCheckURLs {
+		if err := probeURL(url, probeClient); err != nil {
+			hc.recordFailure(key, fmt.Errorf("health check url %s: %w", url, err))
+			return
+		}
+	}
+
+	hc.recordSuccess(key, latency)
+}
+
+// probeURL GETs url through client and fails unless the response status
+// is 2xx.
+func probeURL(url string, client *fasthttp.Client) error {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.SetMethod("GET")
+
+	if err := client.Do(req, resp); err != nil {
+		return err
+	}
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode())
+	}
+	return nil
+}
+
+func (hc *healthChecker) statusFor(key string) *proxyHealth {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	st, ok := hc.statuses[key]
+	if !ok {
+		st = &proxyHealth{Healthy: true}
+		hc.statuses[key] = st
+	}
+	return st
+}
+
+func (hc *healthChecker) recordFailure(key string, err error) {
+	st := hc.statusFor(key)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Checks++
+	st.ConsecutiveFails++
+	st.LastError = err.Error()
+	st.LastCheckedAt = time.Now()
+	if st.ConsecutiveFails >= unhealthyThreshold && st.Healthy {
+		st.Healthy = false
+		log.Printf("🔴 Proxy %s marked unhealthy after %d consecutive failures: %v", key, st.ConsecutiveFails, err)
+	}
+}
+
+func (hc *healthChecker) recordSuccess(key string, latency time.Duration) {
+	st := hc.statusFor(key)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	wasUnhealthy := !st.Healthy
+	st.Checks++
+	st.Successes++
+	st.ConsecutiveFails = 0
+	st.Healthy = true
+	st.LastError = ""
+	st.LastCheckedAt = time.Now()
+
+	latencyMs := float64(latency.Milliseconds())
+	if st.AvgLatencyMs == 0 {
+		st.AvgLatencyMs = latencyMs
+	} else {
+		st.AvgLatencyMs = st.AvgLatencyMs*0.8 + latencyMs*0.2
+	}
+
+	if wasUnhealthy {
+		log.Printf("🟢 Proxy %s recovered", key)
+	}
+}
+
+func (hc *healthChecker) isHealthy(p *Proxy) bool {
+	hc.mu.RLock()
+	st, ok := hc.statuses[proxyKey(p)]
+	hc.mu.RUnlock()
+	if !ok {
+		// Not checked yet; assume healthy until the first probe says otherwise.
+		return true
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.Healthy
+}
+
+// filterHealthy drops proxies the health checker has marked unhealthy.
+// With no checker running (e.g. ip_checker_url unset) it is a no-op.
+func filterHealthy(all []Proxy) []Proxy {
+	if checker == nil {
+		return all
+	}
+	var out []Proxy
+	for _, p := range all {
+		if checker.isHealthy(&p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// fetchEgressIP GETs url and returns the trimmed response body, which
+// an IP-checker endpoint is expected to return as plain text.
+func fetchEgressIP(url string, client *fasthttp.Client) (string, error) {
+	if client == nil {
+		client = &fasthttp.Client{ReadTimeout: healthCheckTimeout}
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.SetMethod("GET")
+
+	if err := client.Do(req, resp); err != nil {
+		return "", err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return "", fmt.Errorf("ip checker returned status %d", resp.StatusCode())
+	}
+	return strings.TrimSpace(string(resp.Body())), nil
+}
+
+// proxyHealthReport is the JSON shape served at /health/proxies.
+type proxyHealthReport struct {
+	Proxy         string    `json:"proxy"`
+	Pool          string    `json:"pool"`
+	Healthy       bool      `json:"healthy"`
+	Checks        int       `json:"checks"`
+	SuccessRate   float64   `json:"successRate"`
+	AvgLatencyMs  float64   `json:"avgLatencyMs"`
+	LastError     string    `json:"lastError,omitempty"`
+	LastCheckedAt time.Time `json:"lastCheckedAt"`
+}
+
+func (hc *healthChecker) snapshot(all []Proxy) []proxyHealthReport {
+	reports := make([]proxyHealthReport, 0, len(all))
+	for _, p := range all {
+		key := proxyKey(&p)
+		report := proxyHealthReport{Proxy: key, Pool: p.Pool, Healthy: true}
+
+		hc.mu.RLock()
+		st, ok := hc.statuses[key]
+		hc.mu.RUnlock()
+
+		if ok {
+			st.mu.Lock()
+			report.Healthy = st.Healthy
+			report.Checks = st.Checks
+			report.AvgLatencyMs = st.AvgLatencyMs
+			report.LastError = st.LastError
+			report.LastCheckedAt = st.LastCheckedAt
+			if st.Checks > 0 {
+				report.SuccessRate = float64(st.Successes) / float64(st.Checks)
+			}
+			st.mu.Unlock()
+		}
+
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+func healthProxiesHandler(ctx *fasthttp.RequestCtx) {
+	if checker == nil {
+		ctx.SetContentType("application/json")
+		ctx.SetBody([]byte("[]"))
+		return
+	}
+
+	body, err := json.Marshal(checker.snapshot(proxies))
+	if err != nil {
+		ctx.Error("failed to marshal health report", fasthttp.StatusInternalServerError)
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
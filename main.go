@@ -1,15 +1,8 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
-	"fmt"
 	"log"
-	"math/rand"
 	"net"
-	"net/url"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,51 +10,36 @@ import (
 	"golang.org/x/net/proxy"
 )
 
-var timeout = 30
-var retries = 3
-var port = "8080"
-var proxies []Proxy
-
-type Proxy struct {
-	ID        string   `json:"_id"`
-	IP        string   `json:"ip"`
-	Port      string   `json:"port"`
-	Protocols []string `json:"protocols"`
-	Latency   float64  `json:"latency"`
-	UpTime    float64  `json:"upTime"`
-	ASN       string   `json:"asn"`
-	Country   string   `json:"country"`
-	City      string   `json:"city"`
-	ISP       string   `json:"isp"`
-	Speed     int      `json:"speed"`
-}
-
-type GeoNodeResponse struct {
-	Data []Proxy `json:"data"`
-}
+var (
+	cfg     *Config
+	timeout = 30
+	retries = 3
+	proxies []Proxy
+)
 
 var client *fasthttp.Client
 
 func main() {
-	// Umgebungsvariablen lesen
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = envPort
-	}
-	if envTimeout := os.Getenv("TIMEOUT"); envTimeout != "" {
-		if t, err := strconv.Atoi(envTimeout); err == nil {
-			timeout = t
-		}
+	configPath := "config.yaml"
+
+	loadedCfg, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatalf("❌ Failed to load %s: %v", configPath, err)
 	}
-	if envRetries := os.Getenv("RETRIES"); envRetries != "" {
-		if r, err := strconv.Atoi(envRetries); err == nil {
-			retries = r
-		}
+	cfg = loadedCfg
+
+	timeout = cfg.TimeoutSeconds
+	retries = cfg.Retries
+
+	pools, err := buildPools(cfg)
+	if err != nil {
+		log.Fatalf("❌ Failed to build proxy pools from %s: %v", configPath, err)
 	}
+	proxies = pools
 
-	// Proxies laden
-	loadProxiesFromGeoNode()
+	checker = newHealthChecker(cfg)
+	go checker.start()
 
-	// Haupt-Client für direkte Verbindungen
 	client = &fasthttp.Client{
 		ReadTimeout:         time.Duration(timeout) * time.Second,
 		WriteTimeout:        time.Duration(timeout) * time.Second,
@@ -71,160 +49,76 @@ func main() {
 		}).Dial,
 	}
 
-	log.Printf("🚀 Starting server on port %s", port)
-	log.Printf("⚙️  Configuration: Timeout=%ds, Retries=%d, Proxies=%d", timeout, retries, len(proxies))
+	log.Printf("🚀 Starting server on %s", cfg.Listen)
+	log.Printf("⚙️  Configuration: Timeout=%ds, Retries=%d, Proxies=%d, DomainRules=%d", timeout, retries, len(proxies), len(cfg.DomainRules))
 
-	if err := fasthttp.ListenAndServe(":"+port, requestHandler); err != nil {
+	server := &fasthttp.Server{
+		Handler:           requestHandler,
+		StreamRequestBody: true,
+	}
+	if err := server.ListenAndServe(cfg.Listen); err != nil {
 		log.Fatalf("Error in ListenAndServe: %s", err)
 	}
 }
 
-func loadProxiesFromGeoNode() {
-	log.Printf("🌐 Loading proxies from GeoNode API...")
-
-	req := fasthttp.AcquireRequest()
-	resp := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseRequest(req)
-	defer fasthttp.ReleaseResponse(resp)
-
-	apiUrl := "https://proxylist.geonode.com/api/proxy-list?limit=100&sort_by=lastChecked&sort_type=desc&protocols=socks4,socks5,https"
-	req.SetRequestURI(apiUrl)
-	req.Header.SetMethod("GET")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-
-	apiClient := &fasthttp.Client{
-		ReadTimeout: 15 * time.Second,
-	}
-
-	err := apiClient.Do(req, resp)
-	if err != nil {
-		log.Printf("❌ Failed to connect to GeoNode API: %v", err)
-		loadDefaultProxies()
-		return
-	}
-
-	if resp.StatusCode() != 200 {
-		log.Printf("❌ GeoNode API returned status: %d", resp.StatusCode())
-		loadDefaultProxies()
-		return
-	}
-
-	var geoNodeResponse GeoNodeResponse
-	if err := json.Unmarshal(resp.Body(), &geoNodeResponse); err != nil {
-		log.Printf("❌ Failed to parse JSON response: %v", err)
-		loadDefaultProxies()
-		return
+// getBestProxy picks a proxy for subdomain according to cfg.DomainRules.
+// It returns (nil, true) when the rule for subdomain says to bypass
+// proxies entirely.
+func getBestProxy(subdomain string) (*Proxy, bool) {
+	pool := poolForSubdomain(cfg.DomainRules, subdomain)
+	if pool == "direct" {
+		return nil, true
 	}
 
-	// Nur funktionierende Proxies mit guter UpTime
-	var goodProxies []Proxy
-	for _, proxy := range geoNodeResponse.Data {
-		if proxy.UpTime > 90 && hasValidProtocol(proxy.Protocols) && proxy.Latency < 1000 {
-			goodProxies = append(goodProxies, proxy)
-		}
+	var candidates []Proxy
+	if pool != "" {
+		candidates = proxiesInPool(proxies, pool)
+	} else {
+		candidates = sortProxiesByPriority(proxies)
 	}
-
-	// Nach Priorität sortieren
-	goodProxies = sortProxiesByPriority(goodProxies)
-	proxies = goodProxies
-	
-	log.Printf("✅ Loaded %d proxies (filtered from %d)", len(proxies), len(geoNodeResponse.Data))
-	for i, p := range proxies {
-		if i < 5 { // Zeige nur die ersten 5 an
-			log.Printf("   %d. %s:%s (%s) - %v", i+1, p.IP, p.Port, p.Country, p.Protocols)
+	candidates = filterHealthy(candidates)
+	candidates = filterCircuitAllowed(candidates)
+
+	for len(candidates) > 0 {
+		idx := pickWeightedIndex(candidates)
+		key := proxyKey(&candidates[idx])
+		if scores.tryReserve(key) {
+			return &candidates[idx], false
 		}
+		// Lost the race for this proxy's half-open probe slot (another
+		// request already claimed it); try again among the rest.
+		candidates = append(candidates[:idx], candidates[idx+1:]...)
 	}
 
-	if len(proxies) == 0 {
-		log.Printf("⚠️  No good proxies found, using direct connections only")
-	}
+	return nil, false
 }
 
-func hasValidProtocol(protocols []string) bool {
-	for _, protocol := range protocols {
-		if protocol == "socks4" || protocol == "socks5" || protocol == "https" || protocol == "http" {
-			return true
-		}
-	}
-	return false
-}
-
-func getProxyPriority(proxy *Proxy) int {
-	// Priorität: SOCKS5 > SOCKS4 > HTTPS > HTTP
-	for _, protocol := range proxy.Protocols {
-		switch protocol {
-		case "socks5":
-			return 4
-		case "socks4":
-			return 3
-		case "https":
-			return 2
-		case "http":
-			return 1
-		}
-	}
-	return 0
-}
-
-func sortProxiesByPriority(proxies []Proxy) []Proxy {
-	sorted := make([]Proxy, len(proxies))
-	copy(sorted, proxies)
-	
-	for i := 0; i < len(sorted)-1; i++ {
-		for j := i + 1; j < len(sorted); j++ {
-			if getProxyPriority(&sorted[j]) > getProxyPriority(&sorted[i]) {
-				sorted[i], sorted[j] = sorted[j], sorted[i]
-			}
-		}
-	}
-	return sorted
-}
+func requestHandler(ctx *fasthttp.RequestCtx) {
+	log.Printf("📨 Received request: %s %s", ctx.Method(), ctx.RequestURI())
 
-func loadDefaultProxies() {
-	log.Printf("⚠️  Using default fallback proxies")
-	proxies = []Proxy{
-		{IP: "104.16.202.9", Port: "80", Protocols: []string{"http"}, Country: "CA", UpTime: 100},
-		{IP: "104.21.237.193", Port: "80", Protocols: []string{"http"}, Country: "CA", UpTime: 100},
+	if string(ctx.Path()) == "/health/proxies" {
+		healthProxiesHandler(ctx)
+		return
 	}
-}
 
-func getBestProxy() *Proxy {
-	if len(proxies) == 0 {
-		return nil
-	}
-	
-	// Versuche die besten Proxies zuerst (sind schon sortiert)
-	rand.Seed(time.Now().UnixNano())
-	if len(proxies) > 3 {
-		// Wähle zufällig aus den besten 25%
-		topCount := len(proxies) / 4
-		if topCount < 1 {
-			topCount = 1
-		}
-		return &proxies[rand.Intn(topCount)]
+	path := string(ctx.Path())
+	if len(path) > 0 && path[0] == '/' {
+		path = path[1:]
 	}
-	
-	return &proxies[rand.Intn(len(proxies))]
-}
-
-func requestHandler(ctx *fasthttp.RequestCtx) {
-	log.Printf("📨 Received request: %s %s", ctx.Method(), ctx.RequestURI())
-
-	// URL Validation
-	path := string(ctx.RequestURI())[1:]
 	if path == "" {
-		ctx.Error("Please provide a URL path", 400)
+		ctx.Error("Please provide a URL path", fasthttp.StatusBadRequest)
 		return
 	}
-
-	parts := strings.SplitN(path, "/", 2)
-	if len(parts) < 2 {
-		ctx.Error("URL format invalid. Expected: /subdomain/path", 400)
+	if len(strings.SplitN(path, "/", 2)) < 2 {
+		ctx.Error("URL format invalid. Expected: /subdomain/path", fasthttp.StatusBadRequest)
 		return
 	}
 
-	response := makeRequest(ctx, 1)
+	response, err := makeRequest(ctx)
+	if err != nil {
+		writeRequestError(ctx, err)
+		return
+	}
 	defer fasthttp.ReleaseResponse(response)
 
 	ctx.SetStatusCode(response.StatusCode())
@@ -234,154 +128,171 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 	})
 }
 
-func makeRequest(ctx *fasthttp.RequestCtx, attempt int) *fasthttp.Response {
-	if attempt > retries {
-		log.Printf("❌ MAX RETRIES EXCEEDED after %d attempts", retries)
-		resp := fasthttp.AcquireResponse()
-		resp.SetStatusCode(502)
-		resp.SetBody([]byte("Proxy failed to connect. Please try again later."))
-		return resp
+// makeRequest proxies ctx's request to Roblox, trying a direct
+// connection first and then up to retries-1 proxied attempts. On
+// success it returns the upstream *fasthttp.Response (caller owns
+// releasing it); on exhaustion it returns a *requestError the caller
+// maps to a status code.
+func makeRequest(ctx *fasthttp.RequestCtx) (*fasthttp.Response, error) {
+	path := string(ctx.Path())
+	if len(path) > 0 && path[0] == '/' {
+		path = path[1:]
+	}
+	parts := strings.SplitN(path, "/", 2)
+	subdomain := parts[0]
+	upstreamPath := ""
+	if len(parts) > 1 {
+		upstreamPath = parts[1]
 	}
 
-	// Immer erst direkten Versuch, dann Proxy
-	useProxy := attempt > 1 && len(proxies) > 0
-	var proxy *Proxy
-	if useProxy {
-		proxy = getBestProxy()
+	targetURL := "https://" + subdomain + ".roblox.com/" + upstreamPath
+	if qs := ctx.URI().QueryString(); len(qs) > 0 {
+		targetURL += "?" + string(qs)
 	}
 
-	req := fasthttp.AcquireRequest()
-	defer fasthttp.ReleaseRequest(req)
+	var lastErr error
+	var lastProxyAddr string
+	statusesSeen := map[int]bool{}
+	var lastStatus int
+
+	pool := poolForSubdomain(cfg.DomainRules, subdomain)
+
+	for attempt := 1; attempt <= retries; attempt++ {
+		// Domain rules that pin a subdomain to a pool (or force direct)
+		// must be honored from the first attempt; subdomains with no
+		// rule still try direct first and only fall back to a proxy on
+		// retry.
+		var proxyToUse *Proxy
+		var forceDirect bool
+		useProxy := attempt > 1 || pool != ""
+		if useProxy {
+			proxyToUse, forceDirect = getBestProxy(subdomain)
+			if forceDirect || proxyToUse == nil {
+				useProxy = false
+			}
+		}
 
-	path := string(ctx.RequestURI())[1:]
-	parts := strings.SplitN(path, "/", 2)
-	targetURL := "https://" + parts[0] + ".roblox.com/" + parts[1]
+		req := fasthttp.AcquireRequest()
+		req.SetRequestURI(targetURL)
+		req.Header.SetMethod(string(ctx.Method()))
+		copyRequestHeaders(&req.Header, &ctx.Request.Header)
+		if bodyStream := ctx.RequestBodyStream(); bodyStream != nil {
+			req.SetBodyStream(bodyStream, ctx.Request.Header.ContentLength())
+		} else {
+			req.SetBody(ctx.Request.Body())
+		}
 
-	log.Printf("🔗 Attempt %d/%d: %s -> %s (Proxy: %t)", attempt, retries, ctx.RequestURI(), targetURL, useProxy)
+		log.Printf("🔗 Attempt %d/%d: %s -> %s (Proxy: %t)", attempt, retries, ctx.RequestURI(), targetURL, useProxy)
 
-	req.SetRequestURI(targetURL)
-	req.Header.SetMethod(string(ctx.Method()))
-	req.SetBody(ctx.Request.Body())
+		resp := fasthttp.AcquireResponse()
+		startTime := time.Now()
+
+		var err error
+		if useProxy && proxyToUse != nil {
+			lastProxyAddr = proxyKey(proxyToUse)
+			log.Printf("🌐 Using proxy: %s:%s (pool: %s) - Protocols: %v", proxyToUse.IP, proxyToUse.Port, proxyToUse.Pool, proxyToUse.Protocols)
+
+			proxyDialer, dialerErr := getProxyDialer(proxyToUse)
+			if dialerErr != nil {
+				log.Printf("❌ Proxy dialer creation failed: %v", dialerErr)
+				fasthttp.ReleaseRequest(req)
+				fasthttp.ReleaseResponse(resp)
+				lastErr = dialerErr
+				continue
+			}
 
-	// Headers setzen
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
-	req.Header.Set("Accept", "application/json, text/html, */*")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	req.Header.Del("Host")
-	req.Header.Del("Roblox-Id")
+			proxyClient := &fasthttp.Client{
+				ReadTimeout:  time.Duration(timeout) * time.Second,
+				WriteTimeout: time.Duration(timeout) * time.Second,
+				Dial:         fasthttpDial(proxyDialer),
+			}
+			err = proxyClient.Do(req, resp)
+		} else {
+			log.Printf("🔗 Direct connection attempt")
+			err = client.Do(req, resp)
+		}
 
-	resp := fasthttp.AcquireResponse()
-	startTime := time.Now()
+		duration := time.Since(startTime)
+		fasthttp.ReleaseRequest(req)
 
-	var err error
+		if proxyToUse != nil {
+			key := proxyKey(proxyToUse)
+			if err != nil || resp.StatusCode() >= 500 {
+				scores.recordFailure(key)
+			} else {
+				scores.recordSuccess(key, duration)
+			}
+		}
 
-	if useProxy && proxy != nil {
-		log.Printf("🌐 Using proxy: %s:%s (%s) - Protocols: %v", proxy.IP, proxy.Port, proxy.Country, proxy.Protocols)
-		
-		// Proxy-Dialer basierend auf Protokoll
-		proxyDialer, err := getProxyDialer(proxy)
 		if err != nil {
-			log.Printf("❌ Proxy dialer creation failed: %v", err)
+			log.Printf("❌ Attempt %d/%d failed after %v: %v", attempt, retries, duration, err)
 			fasthttp.ReleaseResponse(resp)
-			return makeRequest(ctx, attempt+1)
-		}
-		
-		proxyClient := &fasthttp.Client{
-			ReadTimeout:  time.Duration(timeout) * time.Second,
-			WriteTimeout: time.Duration(timeout) * time.Second,
-			Dial:         proxyDialer.Dial,
+
+			lastErr = err
+			lastStatus, _ = classifyError(err)
+			statusesSeen[lastStatus] = true
+
+			if attempt < retries {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+			continue
 		}
-		
-		err = proxyClient.Do(req, resp)
-	} else {
-		// Direkte Verbindung
-		log.Printf("🔗 Direct connection attempt")
-		err = client.Do(req, resp)
-	}
 
-	duration := time.Since(startTime)
+		log.Printf("✅ Success! Status: %d, Time: %v, Size: %d bytes", resp.StatusCode(), duration, len(resp.Body()))
+		return resp, nil
+	}
 
-	if err != nil {
-		log.Printf("❌ Attempt %d failed after %v: %v", attempt, duration, err)
-		fasthttp.ReleaseResponse(resp)
-		
-		// Kurze Pause vor nächstem Versuch
-		if attempt < retries {
-			time.Sleep(time.Duration(attempt) * time.Second)
+	status := fasthttp.StatusBadGateway
+	message := "retries exhausted"
+	if lastErr != nil {
+		_, message = classifyError(lastErr)
+		if len(statusesSeen) == 1 {
+			status = lastStatus
 		}
-		
-		return makeRequest(ctx, attempt+1)
 	}
 
-	log.Printf("✅ Success! Status: %d, Time: %v, Size: %d bytes", resp.StatusCode(), duration, len(resp.Body()))
-	return resp
+	return nil, &requestError{status: status, message: message, attempts: retries, lastProxy: lastProxyAddr}
 }
 
 func getProxyDialer(p *Proxy) (proxy.Dialer, error) {
 	proxyAddr := net.JoinHostPort(p.IP, p.Port)
-	
-	// Check for SOCKS proxies first
+
+	var auth *proxy.Auth
+	if p.User != "" {
+		auth = &proxy.Auth{User: p.User, Password: p.Pass}
+	}
+
 	for _, protocol := range p.Protocols {
-		if protocol == "socks5" {
+		switch protocol {
+		case "ssh":
+			log.Printf("   Using SSH tunnel proxy")
+			return getSSHDialer(p)
+		case "socks5":
 			log.Printf("   Using SOCKS5 proxy")
-			return proxy.SOCKS5("tcp", proxyAddr, nil, &net.Dialer{
+			return proxy.SOCKS5("tcp", proxyAddr, auth, &net.Dialer{
 				Timeout: time.Duration(timeout) * time.Second,
 			})
-		}
-		if protocol == "socks4" {
+		case "socks4":
 			log.Printf("   Using SOCKS4 proxy")
-			return proxy.SOCKS4("tcp", proxyAddr, nil, &net.Dialer{
-				Timeout: time.Duration(timeout) * time.Second,
-			})
+			return newSocks4Dialer(proxyAddr, p.User, time.Duration(timeout)*time.Second), nil
 		}
 	}
-	
+
 	// Fallback to HTTP proxy
 	log.Printf("   Using HTTP proxy (with CONNECT)")
 	return &httpConnectDialer{
 		proxyAddr: proxyAddr,
 		timeout:   time.Duration(timeout) * time.Second,
+		user:      p.User,
+		pass:      p.Pass,
 	}, nil
 }
 
-// HTTP CONNECT dialer implementation
-type httpConnectDialer struct {
-	proxyAddr string
-	timeout   time.Duration
-}
-
-func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
-	conn, err := net.DialTimeout("tcp", d.proxyAddr, d.timeout)
-	if err != nil {
-		return nil, err
-	}
-	
-	connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr)
-	if _, err := conn.Write([]byte(connectReq)); err != nil {
-		conn.Close()
-		return nil, err
+// fasthttpDial adapts a proxy.Dialer, which dials by (network, addr),
+// to fasthttp.DialFunc, which dials by addr alone over TCP.
+func fasthttpDial(d proxy.Dialer) fasthttp.DialFunc {
+	return func(addr string) (net.Conn, error) {
+		return d.Dial("tcp", addr)
 	}
-	
-	// Read response
-	reader := bufio.NewReader(conn)
-	response, err := reader.ReadString('\n')
-	if err != nil {
-		conn.Close()
-		return nil, err
-	}
-	
-	if !strings.Contains(response, "200") {
-		conn.Close()
-		return nil, fmt.Errorf("CONNECT failed: %s", response)
-	}
-	
-	// Read remaining headers
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil || line == "\r\n" {
-			break
-		}
-	}
-	
-	return conn, nil
 }
+
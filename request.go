@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// hopByHopHeaders are per-connection headers that must not be forwarded
+// to the upstream.
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailers":            true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// blacklistedHeaders are headers we rewrite ourselves or that must
+// never reach Roblox as forwarded from the client.
+var blacklistedHeaders = map[string]bool{
+	"Host":      true,
+	"Roblox-Id": true,
+}
+
+var defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36"
+
+// copyRequestHeaders forwards every client header to dst except
+// hop-by-hop and blacklisted ones, falling back to a default
+// User-Agent when the client didn't send one.
+func copyRequestHeaders(dst, src *fasthttp.RequestHeader) {
+	src.VisitAll(func(key, value []byte) {
+		k := string(key)
+		if hopByHopHeaders[k] || blacklistedHeaders[k] {
+			return
+		}
+		dst.SetBytesKV(key, value)
+	})
+
+	if len(dst.Peek("User-Agent")) == 0 {
+		dst.Set("User-Agent", defaultUserAgent)
+	}
+}
+
+// requestError is what makeRequest returns once it has exhausted its
+// retries; requestHandler maps it straight to a JSON error body.
+type requestError struct {
+	status    int
+	message   string
+	attempts  int
+	lastProxy string
+}
+
+func (e *requestError) Error() string { return e.message }
+
+// classifyError maps a transport error to the status code
+// requestHandler should reply with.
+func classifyError(err error) (int, string) {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return 523, fmt.Sprintf("DNS resolution failed: %v", err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fasthttp.StatusGatewayTimeout, fmt.Sprintf("upstream timed out: %v", err)
+	}
+
+	if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "deadline exceeded") {
+		return fasthttp.StatusGatewayTimeout, fmt.Sprintf("upstream timed out: %v", err)
+	}
+
+	if strings.Contains(err.Error(), "connection refused") {
+		return fasthttp.StatusBadGateway, fmt.Sprintf("connection refused: %v", err)
+	}
+
+	return fasthttp.StatusBadGateway, fmt.Sprintf("upstream request failed: %v", err)
+}
+
+type requestErrorBody struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	Attempts  int    `json:"attempts"`
+	LastProxy string `json:"last_proxy,omitempty"`
+}
+
+// writeRequestError writes err as the small JSON error body the
+// backlog asks for: {code, message, attempts, last_proxy}.
+func writeRequestError(ctx *fasthttp.RequestCtx, err error) {
+	reqErr, ok := err.(*requestError)
+	if !ok {
+		reqErr = &requestError{status: fasthttp.StatusBadGateway, message: err.Error(), attempts: 1}
+	}
+
+	body, marshalErr := json.Marshal(requestErrorBody{
+		Code:      reqErr.status,
+		Message:   reqErr.message,
+		Attempts:  reqErr.attempts,
+		LastProxy: reqErr.lastProxy,
+	})
+	if marshalErr != nil {
+		ctx.Error(reqErr.message, reqErr.status)
+		return
+	}
+
+	ctx.SetStatusCode(reqErr.status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+const (
+	breakerFailureThreshold = 5
+	breakerCooldown         = 30 * time.Second
+	assumedLatencyMs        = 200 // neutral starting point for unscored proxies
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// proxyScore is the live, request-path view of a proxy: an EWMA
+// latency, a success ratio, and circuit-breaker state. It's distinct
+// from proxyHealth, which reflects the background health checker.
+type proxyScore struct {
+	mu                    sync.Mutex
+	ewmaLatencyMs         float64
+	successes             int
+	failures              int
+	consecutiveFails      int
+	state                 circuitState
+	openedAt              time.Time
+	halfOpenProbeInFlight bool
+}
+
+type scoreboard struct {
+	mu     sync.Mutex
+	scores map[string]*proxyScore
+}
+
+var scores = &scoreboard{scores: make(map[string]*proxyScore)}
+
+func (sb *scoreboard) get(key string) *proxyScore {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	s, ok := sb.scores[key]
+	if !ok {
+		s = &proxyScore{state: circuitClosed}
+		sb.scores[key] = s
+	}
+	return s
+}
+
+// recordSuccess restores the proxy's score and closes its breaker.
+func (sb *scoreboard) recordSuccess(key string, latency time.Duration) {
+	s := sb.get(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.successes++
+	s.consecutiveFails = 0
+
+	latencyMs := float64(latency.Milliseconds())
+	if s.ewmaLatencyMs == 0 {
+		s.ewmaLatencyMs = latencyMs
+	} else {
+		s.ewmaLatencyMs = s.ewmaLatencyMs*0.8 + latencyMs*0.2
+	}
+
+	if s.state != circuitClosed {
+		log.Printf("🟢 Circuit for %s closed after a successful request", key)
+	}
+	s.state = circuitClosed
+	s.halfOpenProbeInFlight = false
+}
+
+// recordFailure counts a failed request and opens the breaker after
+// breakerFailureThreshold consecutive failures.
+func (sb *scoreboard) recordFailure(key string) {
+	s := sb.get(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures++
+	s.consecutiveFails++
+	s.halfOpenProbeInFlight = false
+
+	if s.consecutiveFails >= breakerFailureThreshold && s.state != circuitOpen {
+		s.state = circuitOpen
+		s.openedAt = time.Now()
+		log.Printf("🔴 Circuit for %s opened after %d consecutive failures", key, s.consecutiveFails)
+	}
+}
+
+// eligible reports whether key's breaker currently permits selection,
+// without claiming anything. Safe to call once per candidate while
+// filtering a pool; use tryReserve on the single candidate actually
+// dispatched.
+func (sb *scoreboard) eligible(key string) bool {
+	s := sb.get(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case circuitOpen:
+		return time.Since(s.openedAt) >= breakerCooldown
+	case circuitHalfOpen:
+		return !s.halfOpenProbeInFlight
+	default: // circuitClosed
+		return true
+	}
+}
+
+// tryReserve claims the right to actually use key for one request. It
+// must be called exactly once, right before dispatch, on the single
+// proxy chosen to handle the request — never while merely filtering a
+// pool — so that only the dispatched request can consume a half-open
+// probe slot. Returns false if another request already holds that
+// slot or the breaker is still cooling down.
+func (sb *scoreboard) tryReserve(key string) bool {
+	s := sb.get(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case circuitOpen:
+		if time.Since(s.openedAt) < breakerCooldown {
+			return false
+		}
+		s.state = circuitHalfOpen
+		s.halfOpenProbeInFlight = true
+		return true
+	case circuitHalfOpen:
+		if s.halfOpenProbeInFlight {
+			return false
+		}
+		s.halfOpenProbeInFlight = true
+		return true
+	default: // circuitClosed
+		return true
+	}
+}
+
+// weight combines success rate and latency into a single selection
+// score; higher is better. Proxies with no history yet get a neutral
+// weight so they get a chance to prove themselves.
+func (sb *scoreboard) weight(key string) float64 {
+	s := sb.get(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := s.successes + s.failures
+	successRate := 1.0
+	if total > 0 {
+		successRate = float64(s.successes) / float64(total)
+	}
+
+	latencyMs := s.ewmaLatencyMs
+	if latencyMs <= 0 {
+		latencyMs = assumedLatencyMs
+	}
+
+	return successRate * (1000.0 / (latencyMs + 100))
+}
+
+// filterCircuitAllowed drops proxies whose breaker currently blocks
+// selection. It only reads state; it never claims a half-open probe
+// slot, so it's safe to call once per candidate.
+func filterCircuitAllowed(all []Proxy) []Proxy {
+	var out []Proxy
+	for _, p := range all {
+		if scores.eligible(proxyKey(&p)) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// pickWeightedIndex does a weighted-random draw over candidates,
+// replacing the old "random pick from the top 25%" selection.
+func pickWeightedIndex(candidates []Proxy) int {
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, p := range candidates {
+		weights[i] = scores.weight(proxyKey(&p))
+		total += weights[i]
+	}
+	if total <= 0 {
+		return rand.Intn(len(candidates))
+	}
+
+	r := rand.Float64() * total
+	for i, w := range weights {
+		r -= w
+		if r <= 0 {
+			return i
+		}
+	}
+	return len(candidates) - 1
+}
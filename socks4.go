@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// socks4Dialer implements proxy.Dialer for a SOCKS4 (and SOCKS4a, for
+// domain-name targets) proxy. golang.org/x/net/proxy only exports a
+// SOCKS5 constructor, so SOCKS4 is hand-rolled here.
+type socks4Dialer struct {
+	proxyAddr string
+	userID    string
+	timeout   time.Duration
+}
+
+func newSocks4Dialer(proxyAddr, userID string, timeout time.Duration) *socks4Dialer {
+	return &socks4Dialer{proxyAddr: proxyAddr, userID: userID, timeout: timeout}
+}
+
+func (d *socks4Dialer) Dial(network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS4 target %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("invalid SOCKS4 target port %q", portStr)
+	}
+
+	conn, err := net.DialTimeout("tcp", d.proxyAddr, d.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if d.timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(d.timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// SOCKS4 request: VN(1)=4, CD(1)=1 (connect), DSTPORT(2), DSTIP(4),
+	// USERID, NULL. A domain name (SOCKS4a) is signalled by an invalid
+	// DSTIP of 0.0.0.x and the hostname appended after the NULL.
+	req := []byte{0x04, 0x01, byte(port >> 8), byte(port)}
+	ip := net.ParseIP(host).To4()
+	useSocks4a := ip == nil
+	if useSocks4a {
+		req = append(req, 0, 0, 0, 1)
+	} else {
+		req = append(req, ip...)
+	}
+	req = append(req, []byte(d.userID)...)
+	req = append(req, 0)
+	if useSocks4a {
+		req = append(req, []byte(host)...)
+		req = append(req, 0)
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("writing SOCKS4 request to %s: %w", d.proxyAddr, err)
+	}
+
+	resp := make([]byte, 8)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading SOCKS4 response from %s: %w", d.proxyAddr, err)
+	}
+	if resp[0] != 0x00 {
+		conn.Close()
+		return nil, fmt.Errorf("malformed SOCKS4 response from %s", d.proxyAddr)
+	}
+	if resp[1] != 0x5a {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS4 proxy %s rejected connection to %s (code 0x%02x)", d.proxyAddr, addr, resp[1])
+	}
+
+	if d.timeout > 0 {
+		if err := conn.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+const sshKeepaliveInterval = 30 * time.Second
+
+var (
+	sshClientsMu sync.Mutex
+	sshClients   = map[string]*ssh.Client{}
+	sshDialing   = map[string]chan struct{}{}
+)
+
+// sshDialer tunnels TCP connections through a cached *ssh.Client for
+// proxy p, reconnecting if the cached client has gone away.
+type sshDialer struct {
+	key string
+	p   *Proxy
+}
+
+func getSSHDialer(p *Proxy) (proxy.Dialer, error) {
+	return &sshDialer{key: sshEndpointKey(p), p: p}, nil
+}
+
+func sshEndpointKey(p *Proxy) string {
+	return fmt.Sprintf("%s@%s", p.User, net.JoinHostPort(p.IP, p.Port))
+}
+
+func (d *sshDialer) Dial(network, addr string) (net.Conn, error) {
+	client, err := d.client()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := client.Dial(network, addr)
+	if err == nil {
+		return conn, nil
+	}
+
+	// The cached client may be dead; drop it and retry once with a fresh one.
+	evictSSHClient(d.key, client)
+	client, err = d.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, addr)
+}
+
+// client returns the cached *ssh.Client for d.key, dialing a fresh one
+// if needed. Concurrent first-time callers for the same key block on
+// the in-flight dial instead of each starting their own, so only one
+// *ssh.Client (and keepalive goroutine) is ever created per endpoint.
+func (d *sshDialer) client() (*ssh.Client, error) {
+	for {
+		sshClientsMu.Lock()
+		if client, ok := sshClients[d.key]; ok {
+			sshClientsMu.Unlock()
+			return client, nil
+		}
+		if inFlight, ok := sshDialing[d.key]; ok {
+			sshClientsMu.Unlock()
+			<-inFlight
+			continue
+		}
+		inFlight := make(chan struct{})
+		sshDialing[d.key] = inFlight
+		sshClientsMu.Unlock()
+
+		client, err := dialSSH(d.p)
+
+		sshClientsMu.Lock()
+		delete(sshDialing, d.key)
+		if err == nil {
+			sshClients[d.key] = client
+		}
+		sshClientsMu.Unlock()
+		close(inFlight)
+
+		if err != nil {
+			return nil, err
+		}
+		go keepaliveSSHClient(d.key, client)
+		return client, nil
+	}
+}
+
+func dialSSH(p *Proxy) (*ssh.Client, error) {
+	auth, err := sshAuthMethod(p)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := sshHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(p.IP, p.Port)
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            p.User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         time.Duration(timeout) * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dialing ssh proxy %s: %w", addr, err)
+	}
+	return client, nil
+}
+
+func sshAuthMethod(p *Proxy) (ssh.AuthMethod, error) {
+	if p.KeyPath != "" {
+		key, err := os.ReadFile(p.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading ssh key %q: %w", p.KeyPath, err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ssh key %q: %w", p.KeyPath, err)
+		}
+		return ssh.PublicKeys(signer), nil
+	}
+	return ssh.Password(p.Pass), nil
+}
+
+func sshHostKeyCallback() (ssh.HostKeyCallback, error) {
+	if cfg.SSH.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	if cfg.SSH.KnownHostsFile == "" {
+		return nil, fmt.Errorf("ssh pool entries require ssh.known_hosts_file or ssh.insecure_ignore_host_key in config")
+	}
+	return knownhosts.New(cfg.SSH.KnownHostsFile)
+}
+
+// keepaliveSSHClient pings client on an interval so idle connections
+// aren't dropped by NATs/firewalls, evicting it from the cache the
+// moment a ping fails so the next Dial reconnects.
+func keepaliveSSHClient(key string, client *ssh.Client) {
+	ticker := time.NewTicker(sshKeepaliveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !sshClientIsCurrent(key, client) {
+			return
+		}
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			evictSSHClient(key, client)
+			return
+		}
+	}
+}
+
+func sshClientIsCurrent(key string, client *ssh.Client) bool {
+	sshClientsMu.Lock()
+	defer sshClientsMu.Unlock()
+	return sshClients[key] == client
+}
+
+func evictSSHClient(key string, client *ssh.Client) {
+	sshClientsMu.Lock()
+	if sshClients[key] == client {
+		delete(sshClients, key)
+	}
+	sshClientsMu.Unlock()
+	client.Close()
+}